@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAuditAppID(t *testing.T) {
+	cases := map[string]string{
+		"/apps/app-1/deploy": "app-1",
+		"/apps/app-1":        "app-1",
+		"/policies":          "",
+		"/":                  "",
+	}
+	for path, want := range cases {
+		if got := auditAppID(path); got != want {
+			t.Errorf("auditAppID(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestAuditAction(t *testing.T) {
+	a := auditAction("POST", "/apps/app-1/deploy")
+	b := auditAction("POST", "/apps/app-2/deploy")
+	if a != b {
+		t.Fatalf("expected actions for the same route to match regardless of app id, got %q and %q", a, b)
+	}
+	if a != "POST /apps/:apps_id/deploy" {
+		t.Fatalf("unexpected action: %q", a)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	router "github.com/flynn/flynn/router/types"
+	que "github.com/flynn/que-go"
+	"golang.org/x/net/context"
+)
+
+// GetCatalog lists every installable stack the controller knows about.
+func (c *controllerAPI) GetCatalog(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	httphelper.JSON(w, 200, c.catalogRepo.List())
+}
+
+// GetCatalogEntry returns a single catalog entry by slug.
+func (c *controllerAPI) GetCatalogEntry(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	entry, err := c.catalogRepo.Get(params.ByName("slug"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, entry)
+}
+
+// InstallCatalogEntry creates an app from a catalog entry: it pins the
+// entry's artifact, creates a release, provisions the declared provider
+// resources, scales the default formation, and registers the declared
+// routes and volumes. The work happens on the que-go worker so a slow
+// provisioner doesn't hold the request open; InstallCatalogEntry itself
+// only does the synchronous, cheap part (creating the app row) before
+// handing the rest off as an Operation, the same split used by the other
+// expensive endpoints.
+func (c *controllerAPI) InstallCatalogEntry(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	entry, err := c.catalogRepo.Get(params.ByName("slug"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	var install ct.CatalogInstallRequest
+	if err := httphelper.DecodeJSON(req, &install); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if install.AppName == "" {
+		respondWithError(w, ct.ValidationError{Field: "app_name", Message: "must be set"})
+		return
+	}
+
+	app := &ct.App{Name: install.AppName}
+	if err := c.appRepo.Add(app); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	op, err := c.operationRepo.Add("catalog.install", "catalog_install", map[string]interface{}{
+		"app_id": app.ID,
+		"slug":   entry.Slug,
+		"env":    install.Env,
+	})
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	op.Links = []ct.OperationLink{{Rel: "app", Href: "/apps/" + app.ID}}
+	respondAsync(w, op)
+}
+
+// installCatalogEntry performs the actual provisioning described by a
+// catalog entry against an already-created app. It's invoked by the
+// catalog_install que-go worker, not directly from the HTTP handler,
+// mirroring how deployments drive their state machine off the queue
+// rather than the request goroutine.
+func (c *controllerAPI) installCatalogEntry(app *ct.App, entry *ct.CatalogEntry, env map[string]string) error {
+	mergedEnv := make(map[string]string, len(entry.Env)+len(env))
+	for k, v := range entry.Env {
+		mergedEnv[k] = v
+	}
+	for k, v := range env {
+		mergedEnv[k] = v
+	}
+
+	artifact := &ct.Artifact{URI: entry.ArtifactURI}
+	if err := c.artifactRepo.Add(artifact); err != nil {
+		return err
+	}
+
+	release := &ct.Release{ArtifactIDs: []string{artifact.ID}, Env: mergedEnv}
+	if err := c.releaseRepo.Add(release); err != nil {
+		return err
+	}
+	if err := c.appRepo.SetRelease(app, release.ID); err != nil {
+		return err
+	}
+
+	for _, res := range entry.Resources {
+		provider, err := c.providerRepo.GetByName(res.Provider)
+		if err != nil {
+			return err
+		}
+		if _, err := c.resourceRepo.Add(provider.(*ct.Provider).ID, []string{app.ID}, nil); err != nil {
+			return err
+		}
+	}
+
+	if len(entry.Formation) > 0 {
+		formation := &ct.Formation{AppID: app.ID, ReleaseID: release.ID, Processes: entry.Formation}
+		if err := c.formationRepo.Add(formation); err != nil {
+			return err
+		}
+	}
+
+	for _, rt := range entry.Routes {
+		route := (&router.HTTPRoute{Domain: rt.Domain, Service: app.Name + "-" + rt.Service}).ToRoute()
+		route.ParentRef = routeParentRef(app.ID)
+		if err := c.routeRepo.Add(route); err != nil {
+			return err
+		}
+	}
+
+	for _, vc := range entry.Volumes {
+		if err := c.volumeRepo.AddClaim(app.ID, vc.ProcessType, vc.Path); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.applyClusterResourceTemplates(app); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// catalogInstallJobArgs is the JSON shape of the "catalog_install" que-go
+// job's Args, as built by InstallCatalogEntry.
+type catalogInstallJobArgs struct {
+	OperationID string            `json:"operation_id"`
+	AppID       string            `json:"app_id"`
+	Slug        string            `json:"slug"`
+	Env         map[string]string `json:"env"`
+}
+
+// catalogInstallWorker is the que-go worker for the "catalog_install"
+// queue: it's where InstallCatalogEntry's async work actually happens,
+// reporting success or failure back onto the Operation the HTTP handler
+// already returned to the client.
+func (c *controllerAPI) catalogInstallWorker(job *que.Job) error {
+	var args catalogInstallJobArgs
+	if err := json.Unmarshal(job.Args, &args); err != nil {
+		return err
+	}
+
+	appData, err := c.appRepo.Get(args.AppID)
+	if err != nil {
+		c.operationRepo.Fail(args.OperationID, []string{err.Error()})
+		return err
+	}
+	entry, err := c.catalogRepo.Get(args.Slug)
+	if err != nil {
+		c.operationRepo.Fail(args.OperationID, []string{err.Error()})
+		return err
+	}
+
+	app := appData.(*ct.App)
+	if err := c.installCatalogEntry(app, entry, args.Env); err != nil {
+		c.operationRepo.Fail(args.OperationID, []string{err.Error()})
+		return err
+	}
+
+	links := []ct.OperationLink{{Rel: "app", Href: "/apps/" + app.ID}}
+	return c.operationRepo.Complete(args.OperationID, links, nil)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestCatalogInstallJobArgsRoundTrip guards against the args map
+// InstallCatalogEntry builds for operationRepo.Add drifting out of sync
+// with catalogInstallJobArgs, the shape catalogInstallWorker decodes --
+// exactly the kind of mismatch that silently dropped the job args before.
+func TestCatalogInstallJobArgsRoundTrip(t *testing.T) {
+	args := map[string]interface{}{
+		"operation_id": "catalog.install~abc",
+		"app_id":       "app-1",
+		"slug":         "postgres",
+		"env":          map[string]string{"FOO": "bar"},
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded catalogInstallJobArgs
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.OperationID != "catalog.install~abc" || decoded.AppID != "app-1" || decoded.Slug != "postgres" {
+		t.Fatalf("unexpected decoded args: %+v", decoded)
+	}
+	if decoded.Env["FOO"] != "bar" {
+		t.Fatalf("expected env to round-trip, got %+v", decoded.Env)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	router "github.com/flynn/flynn/router/types"
+	"github.com/inconshreveable/log15"
+	"golang.org/x/net/context"
+)
+
+// CreateClusterResourceTemplate adds a new template.
+func (c *controllerAPI) CreateClusterResourceTemplate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var tmpl ct.ClusterResourceTemplate
+	if err := httphelper.DecodeJSON(req, &tmpl); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := c.clusterResourceTemplateRepo.Add(&tmpl); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &tmpl)
+}
+
+// GetClusterResourceTemplates lists every template.
+func (c *controllerAPI) GetClusterResourceTemplates(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	templates, err := c.clusterResourceTemplateRepo.List()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, templates)
+}
+
+// GetClusterResourceTemplate returns a single template by id.
+func (c *controllerAPI) GetClusterResourceTemplate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	tmpl, err := c.clusterResourceTemplateRepo.Get(params.ByName("template_id"))
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, tmpl)
+}
+
+// DeleteClusterResourceTemplate removes a template.
+func (c *controllerAPI) DeleteClusterResourceTemplate(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	if err := c.clusterResourceTemplateRepo.Remove(params.ByName("template_id")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// applyClusterResourceTemplates applies every template matching app's
+// namespace (app.Meta["namespace"]) to app: it ensures the declared
+// sinks, env, routes and provider resources exist and raises the app's
+// formation floor. Each template's ClusterResourceTemplateRepo.IsApplied
+// state (keyed on the template's UpdatedAt) is checked before doing any
+// of that work, so a template already applied at its current revision
+// is skipped entirely -- that's what makes repeated calls for the same app
+// (from the reconciler on every tick) idempotent,
+// while still re-applying once an operator edits the template.
+func (c *controllerAPI) applyClusterResourceTemplates(app *ct.App) ([]string, error) {
+	templates, err := c.clusterResourceTemplateRepo.ForNamespace(app.Meta["namespace"])
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, tmpl := range templates {
+		upToDate, err := c.clusterResourceTemplateRepo.IsApplied(tmpl, app.ID)
+		if err != nil {
+			return applied, err
+		}
+		if upToDate {
+			continue
+		}
+
+		rendered, err := c.clusterResourceTemplateRepo.Render(tmpl, ct.ClusterResourceTemplateData{App: app})
+		if err != nil {
+			return applied, err
+		}
+
+		for _, sink := range rendered.Sinks {
+			sink.ID = ""
+			if err := c.sinkRepo.Add(sink); err != nil {
+				return applied, err
+			}
+		}
+
+		for _, res := range rendered.Resources {
+			provider, err := c.providerRepo.GetByName(res.Provider)
+			if err != nil {
+				return applied, err
+			}
+			if _, err := c.resourceRepo.Add(provider.(*ct.Provider).ID, []string{app.ID}, nil); err != nil {
+				return applied, err
+			}
+		}
+
+		for _, rt := range rendered.Routes {
+			route := (&router.HTTPRoute{Domain: rt.Domain, Service: app.Name + "-" + rt.Service}).ToRoute()
+			route.ParentRef = routeParentRef(app.ID)
+			if err := c.routeRepo.Add(route); err != nil {
+				return applied, err
+			}
+		}
+
+		if len(rendered.Env) > 0 || len(rendered.Formation) > 0 {
+			appData, err := c.appRepo.Get(app.ID)
+			if err != nil {
+				return applied, err
+			}
+			release, err := c.releaseRepo.Get(appData.(*ct.App).ReleaseID)
+			if err != nil {
+				return applied, err
+			}
+			newRelease := *release.(*ct.Release)
+			newRelease.ID = ""
+			if newRelease.Env == nil {
+				newRelease.Env = make(map[string]string, len(rendered.Env))
+			}
+			for k, v := range rendered.Env {
+				newRelease.Env[k] = v
+			}
+			if err := c.releaseRepo.Add(&newRelease); err != nil {
+				return applied, err
+			}
+			if err := c.appRepo.SetRelease(app, newRelease.ID); err != nil {
+				return applied, err
+			}
+
+			if len(rendered.Formation) > 0 {
+				processes := make(map[string]int, len(rendered.Formation))
+				if current, err := c.formationRepo.Get(app.ID, appData.(*ct.App).ReleaseID); err == nil {
+					for typ, n := range current.Processes {
+						processes[typ] = n
+					}
+				}
+				for typ, floor := range rendered.Formation {
+					if processes[typ] < floor {
+						processes[typ] = floor
+					}
+				}
+				formation := &ct.Formation{AppID: app.ID, ReleaseID: newRelease.ID, Processes: processes}
+				if err := c.formationRepo.Add(formation); err != nil {
+					return applied, err
+				}
+			}
+		}
+
+		if err := c.clusterResourceTemplateRepo.MarkApplied(tmpl, app.ID); err != nil {
+			return applied, err
+		}
+		applied = append(applied, tmpl.ID)
+	}
+	return applied, nil
+}
+
+// templatedAppRepo wraps *data.AppRepo so the generic apps CRUD route
+// (see crud(httpRouter, "apps", ...) in controller.go) applies matching
+// cluster resource templates to an app as part of creating it, instead of
+// only picking it up later via the catalog-install path or the next
+// reconciler tick. Add is the only method overridden; every other verb
+// crud() drives (Get, List, Update, Delete) still goes straight through
+// to the embedded AppRepo.
+type templatedAppRepo struct {
+	*data.AppRepo
+	api *controllerAPI
+}
+
+func (r *templatedAppRepo) Add(thing interface{}) error {
+	app := thing.(*ct.App)
+	if err := r.AppRepo.Add(app); err != nil {
+		return err
+	}
+	if _, err := r.api.applyClusterResourceTemplates(app); err != nil {
+		return err
+	}
+	return nil
+}
+
+// startClusterResourceReconciler periodically walks every app and
+// re-applies any cluster resource template that has drifted, so
+// templates created or edited after an app already exists still
+// converge. It's registered with shutdown.BeforeExit via the stop
+// channel so a controller shutdown stops the loop instead of leaving it
+// running against a closed db.
+func startClusterResourceReconciler(c *controllerAPI, interval time.Duration, stop <-chan struct{}) {
+	log := logger.New("fn", "clusterResourceReconciler")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := c.reconcileClusterResourceTemplates(); err != nil {
+				log.Error("error reconciling cluster resource templates", "err", err)
+			}
+		}
+	}
+}
+
+func (c *controllerAPI) reconcileClusterResourceTemplates() error {
+	apps, err := c.appRepo.List()
+	if err != nil {
+		return err
+	}
+	for _, a := range apps.([]*ct.App) {
+		applied, err := c.applyClusterResourceTemplates(a)
+		if err != nil {
+			logger.New("fn", "reconcileClusterResourceTemplates").Error("error applying templates", "app", a.ID, "err", err)
+			continue
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		if err := c.eventRepo.Add(a.ID, ct.EventTypeClusterResourceSync, log15.Ctx{"templates": applied}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
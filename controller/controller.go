@@ -16,6 +16,7 @@ import (
 	"github.com/flynn/flynn/controller/authorizer"
 	"github.com/flynn/flynn/controller/data"
 	"github.com/flynn/flynn/controller/name"
+	"github.com/flynn/flynn/controller/policy"
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/controller/utils"
@@ -79,6 +80,10 @@ func main() {
 	db := data.OpenAndMigrateDB(nil)
 	shutdown.BeforeExit(func() { db.Close() })
 
+	if err := data.MigrateFeatureTables(db); err != nil {
+		shutdown.Fatal(err)
+	}
+
 	lc, err := logaggc.New("")
 	if err != nil {
 		shutdown.Fatal(err)
@@ -184,35 +189,73 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 	backupRepo := data.NewBackupRepo(c.db)
 	sinkRepo := data.NewSinkRepo(c.db)
 	volumeRepo := data.NewVolumeRepo(c.db)
+	operationRepo, err := data.NewOperationRepo(c.db, q)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	catalogRepo, err := data.NewCatalogRepo(os.Getenv("CATALOG_DIR"), os.Getenv("CATALOG_INDEX_URL"))
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	policyRepo, err := data.NewPolicyRepo(c.db)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	if err := policyRepo.EnsureDefaultPolicy(); err != nil {
+		shutdown.Fatal(err)
+	}
+	clusterResourceTemplateRepo, err := data.NewClusterResourceTemplateRepo(c.db)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	auditRepo, err := data.NewAuditRepo(c.db)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	auditRepo.Forwarder = func(rec *ct.AuditRecord) {
+		forwardAuditRecordToSinks(sinkRepo, rec)
+	}
 
 	api := controllerAPI{
-		domainMigrationRepo: domainMigrationRepo,
-		appRepo:             appRepo,
-		releaseRepo:         releaseRepo,
-		providerRepo:        providerRepo,
-		formationRepo:       formationRepo,
-		artifactRepo:        artifactRepo,
-		jobRepo:             jobRepo,
-		routeRepo:           routeRepo,
-		resourceRepo:        resourceRepo,
-		deploymentRepo:      deploymentRepo,
-		eventRepo:           eventRepo,
-		backupRepo:          backupRepo,
-		sinkRepo:            sinkRepo,
-		volumeRepo:          volumeRepo,
-		clusterClient:       c.cc,
-		logaggc:             c.lc,
-		que:                 q,
-		caCert:              c.caCert,
-		config:              c,
-		authorizer:          authorizer.New(c.keys, c.keyIDs, c.tokenKey, c.tokenMaxValidity),
+		domainMigrationRepo:         domainMigrationRepo,
+		appRepo:                     appRepo,
+		releaseRepo:                 releaseRepo,
+		providerRepo:                providerRepo,
+		formationRepo:               formationRepo,
+		artifactRepo:                artifactRepo,
+		jobRepo:                     jobRepo,
+		routeRepo:                   routeRepo,
+		resourceRepo:                resourceRepo,
+		deploymentRepo:              deploymentRepo,
+		eventRepo:                   eventRepo,
+		backupRepo:                  backupRepo,
+		sinkRepo:                    sinkRepo,
+		volumeRepo:                  volumeRepo,
+		operationRepo:               operationRepo,
+		catalogRepo:                 catalogRepo,
+		policyRepo:                  policyRepo,
+		policyMW:                    policy.New(policyRepo),
+		clusterResourceTemplateRepo: clusterResourceTemplateRepo,
+		auditRepo:                   auditRepo,
+		asyncWorkers:                make(map[string]que.WorkFunc),
+		clusterClient:               c.cc,
+		logaggc:                     c.lc,
+		que:                         q,
+		caCert:                      c.caCert,
+		config:                      c,
+		authorizer:                  authorizer.New(c.keys, c.keyIDs, c.tokenKey, c.tokenMaxValidity),
+		streamDone:                  make(chan struct{}),
 	}
 
 	shutdown.BeforeExit(api.Shutdown)
 
+	reconcilerStopCh := make(chan struct{})
+	shutdown.BeforeExit(func() { close(reconcilerStopCh) })
+	go startClusterResourceReconciler(&api, 1*time.Minute, reconcilerStopCh)
+
 	httpRouter := httprouter.New()
 
-	crud(httpRouter, "apps", ct.App{}, appRepo)
+	crud(httpRouter, "apps", ct.App{}, &templatedAppRepo{AppRepo: appRepo, api: &api})
 	crud(httpRouter, "releases", ct.Release{}, releaseRepo)
 	crud(httpRouter, "providers", ct.Provider{}, providerRepo)
 	crud(httpRouter, "artifacts", ct.Artifact{}, artifactRepo)
@@ -226,77 +269,106 @@ func appHandler(c handlerConfig) (http.Handler, *grpc.Server, *controllerAPI) {
 
 	httpRouter.GET("/ca-cert", httphelper.WrapHandler(api.GetCACert))
 
+	httpRouter.GET("/operations/:id", httphelper.WrapHandler(api.GetOperation))
+
+	httpRouter.GET("/catalog", httphelper.WrapHandler(api.GetCatalog))
+	httpRouter.GET("/catalog/:slug", httphelper.WrapHandler(api.GetCatalogEntry))
+	httpRouter.POST("/catalog/:slug/install", api.policyMW.Wrap("apps.write", nil, httphelper.WrapHandler(api.InstallCatalogEntry)))
+
+	httpRouter.POST("/policies", api.policyMW.Wrap("policies.admin", nil, httphelper.WrapHandler(api.CreatePolicy)))
+	httpRouter.GET("/policies", api.policyMW.Wrap("policies.admin", nil, httphelper.WrapHandler(api.GetPolicies)))
+	httpRouter.DELETE("/policies/:policy_id", api.policyMW.Wrap("policies.admin", nil, httphelper.WrapHandler(api.DeletePolicy)))
+
+	httpRouter.POST("/cluster-resource-templates", api.policyMW.Wrap("cluster-resource-templates.admin", nil, httphelper.WrapHandler(api.CreateClusterResourceTemplate)))
+	httpRouter.GET("/cluster-resource-templates", api.policyMW.Wrap("cluster-resource-templates.admin", nil, httphelper.WrapHandler(api.GetClusterResourceTemplates)))
+	httpRouter.GET("/cluster-resource-templates/:template_id", api.policyMW.Wrap("cluster-resource-templates.admin", nil, httphelper.WrapHandler(api.GetClusterResourceTemplate)))
+	httpRouter.DELETE("/cluster-resource-templates/:template_id", api.policyMW.Wrap("cluster-resource-templates.admin", nil, httphelper.WrapHandler(api.DeleteClusterResourceTemplate)))
+
 	httpRouter.GET("/backup", httphelper.WrapHandler(api.GetBackup))
 
-	httpRouter.PUT("/domain", httphelper.WrapHandler(api.MigrateDomain))
+	httpRouter.PUT("/domain", api.policyMW.Wrap("domain.migrate", nil, api.asyncOperation("domain.migrate", nil, httphelper.WrapHandler(api.MigrateDomain))))
 
-	httpRouter.POST("/apps/:apps_id", httphelper.WrapHandler(api.UpdateApp))
-	httpRouter.GET("/apps/:apps_id/log", httphelper.WrapHandler(api.appLookup(api.AppLog)))
-	httpRouter.DELETE("/apps/:apps_id", httphelper.WrapHandler(api.appLookup(api.DeleteApp)))
-	httpRouter.DELETE("/apps/:apps_id/releases/:releases_id", httphelper.WrapHandler(api.appLookup(api.DeleteRelease)))
-	httpRouter.POST("/apps/:apps_id/gc", httphelper.WrapHandler(api.appLookup(api.ScheduleAppGarbageCollection)))
+	httpRouter.POST("/apps/:apps_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.UpdateApp)))
+	httpRouter.GET("/apps/:apps_id/log", api.policyMW.Wrap("apps.read", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.AppLog))))
+	httpRouter.DELETE("/apps/:apps_id", api.policyMW.Wrap("apps.delete", policy.ObjectParam("apps", "apps_id"), api.asyncOperation("app.delete", appLink, httphelper.WrapHandler(api.appLookup(api.DeleteApp)))))
+	httpRouter.DELETE("/apps/:apps_id/releases/:releases_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.DeleteRelease))))
+	httpRouter.POST("/apps/:apps_id/gc", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), api.asyncOperation("app.gc", appLink, httphelper.WrapHandler(api.appLookup(api.ScheduleAppGarbageCollection)))))
 
-	httpRouter.PUT("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.PutFormation)))
+	httpRouter.PUT("/apps/:apps_id/formations/:releases_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.PutFormation))))
 	httpRouter.GET("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.GetFormation)))
-	httpRouter.DELETE("/apps/:apps_id/formations/:releases_id", httphelper.WrapHandler(api.appLookup(api.DeleteFormation)))
+	httpRouter.DELETE("/apps/:apps_id/formations/:releases_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.DeleteFormation))))
 	httpRouter.GET("/apps/:apps_id/formations", httphelper.WrapHandler(api.appLookup(api.ListFormations)))
 	httpRouter.GET("/formations", httphelper.WrapHandler(api.GetFormations))
 
-	httpRouter.PUT("/apps/:apps_id/scale/:releases_id", httphelper.WrapHandler(api.appLookup(api.PutScaleRequest)))
+	httpRouter.PUT("/apps/:apps_id/scale/:releases_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.PutScaleRequest))))
 
-	httpRouter.POST("/apps/:apps_id/jobs", httphelper.WrapHandler(api.appLookup(api.RunJob)))
+	httpRouter.POST("/apps/:apps_id/jobs", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.RunJob))))
 	httpRouter.GET("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.GetJob))
-	httpRouter.PUT("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.PutJob))
+	httpRouter.PUT("/apps/:apps_id/jobs/:jobs_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.PutJob)))
 	httpRouter.GET("/apps/:apps_id/jobs", httphelper.WrapHandler(api.appLookup(api.ListJobs)))
-	httpRouter.DELETE("/apps/:apps_id/jobs/:jobs_id", httphelper.WrapHandler(api.KillJob))
+	httpRouter.DELETE("/apps/:apps_id/jobs/:jobs_id", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.KillJob)))
 	httpRouter.GET("/active-jobs", httphelper.WrapHandler(api.ListActiveJobs))
 
-	httpRouter.POST("/apps/:apps_id/deploy", httphelper.WrapHandler(api.appLookup(api.CreateDeployment)))
+	httpRouter.POST("/apps/:apps_id/deploy", api.policyMW.Wrap("apps.deploy", policy.ObjectParam("apps", "apps_id"), api.asyncOperation("deployment.create", appLink, httphelper.WrapHandler(api.appLookup(api.CreateDeployment)))))
 	httpRouter.GET("/apps/:apps_id/deployments", httphelper.WrapHandler(api.appLookup(api.ListDeployments)))
 	httpRouter.GET("/deployments/:deployment_id", httphelper.WrapHandler(api.GetDeployment))
 
-	httpRouter.PUT("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.SetAppRelease)))
+	httpRouter.PUT("/apps/:apps_id/release", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.SetAppRelease))))
 	httpRouter.GET("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.GetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/releases", httphelper.WrapHandler(api.appLookup(api.GetAppReleases)))
 
 	httpRouter.GET("/resources", httphelper.WrapHandler(api.GetResources))
-	httpRouter.POST("/providers/:providers_id/resources", httphelper.WrapHandler(api.ProvisionResource))
+	httpRouter.POST("/providers/:providers_id/resources", api.policyMW.Wrap("resources.write", nil, api.asyncOperation("resource.provision", providerLink, httphelper.WrapHandler(api.ProvisionResource))))
 	httpRouter.GET("/providers/:providers_id/resources", httphelper.WrapHandler(api.GetProviderResources))
 	httpRouter.GET("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.GetResource))
-	httpRouter.PUT("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.PutResource))
-	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id", httphelper.WrapHandler(api.DeleteResource))
-	httpRouter.PUT("/providers/:providers_id/resources/:resources_id/apps/:app_id", httphelper.WrapHandler(api.AddResourceApp))
-	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id/apps/:app_id", httphelper.WrapHandler(api.DeleteResourceApp))
+	httpRouter.PUT("/providers/:providers_id/resources/:resources_id", api.policyMW.Wrap("resources.write", nil, httphelper.WrapHandler(api.PutResource)))
+	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id", api.policyMW.Wrap("resources.write", nil, httphelper.WrapHandler(api.DeleteResource)))
+	httpRouter.PUT("/providers/:providers_id/resources/:resources_id/apps/:app_id", api.policyMW.Wrap("resources.write", nil, httphelper.WrapHandler(api.AddResourceApp)))
+	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id/apps/:app_id", api.policyMW.Wrap("resources.write", nil, httphelper.WrapHandler(api.DeleteResourceApp)))
 	httpRouter.GET("/apps/:apps_id/resources", httphelper.WrapHandler(api.appLookup(api.GetAppResources)))
 
 	httpRouter.GET("/routes", httphelper.WrapHandler(api.GetRouteList))
-	httpRouter.POST("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.CreateRoute)))
+	httpRouter.POST("/apps/:apps_id/routes", api.policyMW.Wrap("routes.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.CreateRoute))))
 	httpRouter.GET("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.GetAppRouteList)))
 	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.GetRoute)))
-	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.UpdateRoute)))
-	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.DeleteRoute)))
+	httpRouter.PUT("/apps/:apps_id/routes/:routes_type/:routes_id", api.policyMW.Wrap("routes.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.UpdateRoute))))
+	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", api.policyMW.Wrap("routes.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.DeleteRoute))))
 
-	httpRouter.POST("/apps/:apps_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateApp)))
+	httpRouter.POST("/apps/:apps_id/meta", api.policyMW.Wrap("apps.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.UpdateApp))))
 
 	httpRouter.GET("/events", httphelper.WrapHandler(api.Events))
 	httpRouter.GET("/events/:id", httphelper.WrapHandler(api.GetEvent))
 
 	httpRouter.GET("/volumes", httphelper.WrapHandler(api.GetVolumes))
-	httpRouter.PUT("/volumes/:volume_id", httphelper.WrapHandler(api.PutVolume))
+	httpRouter.PUT("/volumes/:volume_id", api.policyMW.Wrap("volumes.write", nil, httphelper.WrapHandler(api.PutVolume)))
 	httpRouter.GET("/apps/:apps_id/volumes", httphelper.WrapHandler(api.appLookup(api.GetAppVolumes)))
 	httpRouter.GET("/apps/:apps_id/volumes/:volume_id", httphelper.WrapHandler(api.appLookup(api.GetVolume)))
-	httpRouter.PUT("/apps/:apps_id/volumes/:volume_id/decommission", httphelper.WrapHandler(api.appLookup(api.DecommissionVolume)))
+	httpRouter.PUT("/apps/:apps_id/volumes/:volume_id/decommission", api.policyMW.Wrap("volumes.write", policy.ObjectParam("apps", "apps_id"), httphelper.WrapHandler(api.appLookup(api.DecommissionVolume))))
 
-	httpRouter.POST("/sinks", httphelper.WrapHandler(api.CreateSink))
+	httpRouter.POST("/sinks", api.policyMW.Wrap("sinks.admin", nil, httphelper.WrapHandler(api.CreateSink)))
 	httpRouter.GET("/sinks", httphelper.WrapHandler(api.GetSinks))
 	httpRouter.GET("/sinks/:sink_id", httphelper.WrapHandler(api.GetSink))
-	httpRouter.DELETE("/sinks/:sink_id", httphelper.WrapHandler(api.DeleteSink))
+	httpRouter.DELETE("/sinks/:sink_id", api.policyMW.Wrap("sinks.admin", nil, httphelper.WrapHandler(api.DeleteSink)))
+
+	httpRouter.GET("/audit", api.policyMW.Wrap("audit.read", nil, httphelper.WrapHandler(api.GetAudit)))
+	httpRouter.GET("/audit/tail", api.policyMW.Wrap("audit.read", nil, httphelper.WrapHandler(api.TailAudit)))
+
+	workers := que.WorkMap{
+		"catalog_install": api.catalogInstallWorker,
+	}
+	for opType, work := range api.asyncWorkers {
+		workers[opType] = work
+	}
+	workerPool := que.NewWorkerPool(q, workers, 10)
+	go workerPool.Start()
+	shutdown.BeforeExit(workerPool.Shutdown)
 
 	grpcAPI := &grpcAPI{&api, c.db}
 	grpcSrv := grpcAPI.grpcServer()
 
 	handler := muxHandler(httpRouter, grpcSrv, api.authorizer)
 	if os.Getenv("AUDIT_LOG") == "true" {
+		handler = newAuditMiddleware(&api)(handler)
 		handler = httphelper.NewRequestLoggerCustom(handler, auditLoggerFn)
 	} else {
 		handler = httphelper.NewRequestLogger(handler)
@@ -342,29 +414,49 @@ func muxHandler(main http.Handler, grpcSrv *grpc.Server, authorizer *authorizer.
 }
 
 type controllerAPI struct {
-	domainMigrationRepo *data.DomainMigrationRepo
-	appRepo             *data.AppRepo
-	releaseRepo         *data.ReleaseRepo
-	providerRepo        *data.ProviderRepo
-	formationRepo       *data.FormationRepo
-	artifactRepo        *data.ArtifactRepo
-	jobRepo             *data.JobRepo
-	routeRepo           *data.RouteRepo
-	resourceRepo        *data.ResourceRepo
-	deploymentRepo      *data.DeploymentRepo
-	eventRepo           *data.EventRepo
-	backupRepo          *data.BackupRepo
-	sinkRepo            *data.SinkRepo
-	volumeRepo          *data.VolumeRepo
-	clusterClient       utils.ClusterClient
-	logaggc             logClient
-	que                 *que.Client
-	caCert              []byte
-	config              handlerConfig
-	authorizer          *authorizer.Authorizer
+	domainMigrationRepo         *data.DomainMigrationRepo
+	appRepo                     *data.AppRepo
+	releaseRepo                 *data.ReleaseRepo
+	providerRepo                *data.ProviderRepo
+	formationRepo               *data.FormationRepo
+	artifactRepo                *data.ArtifactRepo
+	jobRepo                     *data.JobRepo
+	routeRepo                   *data.RouteRepo
+	resourceRepo                *data.ResourceRepo
+	deploymentRepo              *data.DeploymentRepo
+	eventRepo                   *data.EventRepo
+	backupRepo                  *data.BackupRepo
+	sinkRepo                    *data.SinkRepo
+	volumeRepo                  *data.VolumeRepo
+	operationRepo               *data.OperationRepo
+	catalogRepo                 *data.CatalogRepo
+	policyRepo                  *data.PolicyRepo
+	policyMW                    *policy.Middleware
+	clusterResourceTemplateRepo *data.ClusterResourceTemplateRepo
+	auditRepo                   *data.AuditRepo
+
+	// asyncWorkers collects the que-go WorkFunc asyncOperation registers
+	// for each opType it wraps, keyed the same way operationRepo.Add's
+	// queueName is: as routes are registered below, each asyncOperation
+	// call adds its entry here, and appHandler folds the whole map into
+	// the worker pool once route registration (and so every call) is
+	// done.
+	asyncWorkers map[string]que.WorkFunc
+
+	clusterClient utils.ClusterClient
+	logaggc       logClient
+	que           *que.Client
+	caCert        []byte
+	config        handlerConfig
+	authorizer    *authorizer.Authorizer
 
 	eventListener    *data.EventListener
 	eventListenerMtx sync.Mutex
+
+	// streamDone is closed on Shutdown so grpc-web streaming subscribers
+	// (StreamAppLog, StreamEvents) return Unavailable as soon as the
+	// controller starts shutting down.
+	streamDone chan struct{}
 }
 
 func (c *controllerAPI) getApp(ctx context.Context) *ct.App {
@@ -427,4 +519,5 @@ func (c *controllerAPI) Shutdown() {
 	if c.eventListener != nil {
 		c.eventListener.CloseWithError(ErrShutdown)
 	}
+	close(c.streamDone)
 }
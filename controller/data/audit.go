@@ -0,0 +1,141 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// AuditRepo persists a record of every mutating request the controller
+// serves, and fans each record out to any subscribed Tail listeners (and,
+// via Forwarder, to configured external sinks) as it's written.
+type AuditRepo struct {
+	db        *postgres.DB
+	Forwarder func(*ct.AuditRecord)
+
+	mtx       sync.Mutex
+	listeners map[chan *ct.AuditRecord]struct{}
+}
+
+var auditStatements = map[string]string{
+	"audit_insert": `INSERT INTO audit_records
+		(method, path, auth_id, auth_user, app_id, action, remote_addr, body_hash, diff, status, latency_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING audit_id`,
+	"audit_list": `SELECT audit_id, method, path, auth_id, auth_user, app_id, action, remote_addr, body_hash, diff, status, latency_ms, created_at
+		FROM audit_records
+		WHERE ($1 = '' OR auth_user = $1)
+		AND ($2 = '' OR app_id = $2)
+		AND ($3 = '' OR action = $3)
+		AND ($4::timestamptz IS NULL OR created_at >= $4)
+		AND ($5::timestamptz IS NULL OR created_at <= $5)
+		AND ($6 = '' OR (created_at, audit_id) < (SELECT created_at, audit_id FROM audit_records WHERE audit_id = $6))
+		ORDER BY created_at DESC, audit_id DESC
+		LIMIT $7`,
+}
+
+func NewAuditRepo(db *postgres.DB) (*AuditRepo, error) {
+	if err := prepareAll(db, auditStatements); err != nil {
+		return nil, err
+	}
+	return &AuditRepo{
+		db:        db,
+		listeners: make(map[chan *ct.AuditRecord]struct{}),
+	}, nil
+}
+
+// Add persists a new audit record and notifies any GET /audit/tail
+// subscribers and the configured sink Forwarder, if set.
+func (r *AuditRepo) Add(rec *ct.AuditRecord) error {
+	rec.CreatedAt = time.Now()
+	err := r.db.QueryRow(
+		"audit_insert",
+		rec.Method, rec.Path, rec.AuthID, rec.AuthUser, rec.AppID, rec.Action, rec.RemoteAddr,
+		rec.BodyHash, rec.Diff, rec.Status, rec.Latency, rec.CreatedAt,
+	).Scan(&rec.ID)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	for ch := range r.listeners {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+	r.mtx.Unlock()
+
+	if r.Forwarder != nil {
+		r.Forwarder(rec)
+	}
+	return nil
+}
+
+// List returns audit records matching filter, newest first.
+func (r *AuditRepo) List(filter ct.AuditFilter) ([]*ct.AuditRecord, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := r.db.Query("audit_list", filter.AuthUser, filter.AppID, filter.Action, filter.Since, filter.Until, filter.Cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []*ct.AuditRecord
+	for rows.Next() {
+		rec := &ct.AuditRecord{}
+		if err := rows.Scan(&rec.ID, &rec.Method, &rec.Path, &rec.AuthID, &rec.AuthUser, &rec.AppID, &rec.Action, &rec.RemoteAddr, &rec.BodyHash, &rec.Diff, &rec.Status, &rec.Latency, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Tail registers ch to receive audit records as they're written, until
+// Untail is called with the same channel. It backs GET /audit/tail. Add
+// never blocks on a listener: a record is dropped for any listener whose
+// channel is full.
+func (r *AuditRepo) Tail(ch chan *ct.AuditRecord) {
+	r.mtx.Lock()
+	r.listeners[ch] = struct{}{}
+	r.mtx.Unlock()
+}
+
+// Untail unregisters a channel previously passed to Tail.
+func (r *AuditRepo) Untail(ch chan *ct.AuditRecord) {
+	r.mtx.Lock()
+	delete(r.listeners, ch)
+	r.mtx.Unlock()
+}
+
+// HashBody returns a stable, non-reversible digest of a request body
+// suitable for persisting instead of the body itself.
+func HashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// RedactedDiff marshals changed as JSON, replacing any key in redact with
+// "[REDACTED]" so e.g. release env values never reach the audit log.
+func RedactedDiff(changed map[string]interface{}, redact map[string]bool) (string, error) {
+	out := make(map[string]interface{}, len(changed))
+	for k, v := range changed {
+		if redact[k] {
+			out[k] = "[REDACTED]"
+			continue
+		}
+		out[k] = v
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,37 @@
+package data
+
+import "testing"
+
+func TestHashBodyStable(t *testing.T) {
+	a := HashBody([]byte(`{"foo":"bar"}`))
+	b := HashBody([]byte(`{"foo":"bar"}`))
+	if a != b {
+		t.Fatalf("expected stable hash, got %q and %q", a, b)
+	}
+	if a == HashBody([]byte(`{"foo":"baz"}`)) {
+		t.Fatalf("expected different bodies to hash differently")
+	}
+}
+
+func TestRedactedDiffRedactsNamedFields(t *testing.T) {
+	diff, err := RedactedDiff(
+		map[string]interface{}{"env": map[string]interface{}{"SECRET": "xyz"}, "name": "myapp"},
+		map[string]bool{"env": true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != `{"env":"[REDACTED]","name":"myapp"}` {
+		t.Fatalf("unexpected diff: %s", diff)
+	}
+}
+
+func TestRedactedDiffNoRedactions(t *testing.T) {
+	diff, err := RedactedDiff(map[string]interface{}{"name": "myapp"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != `{"name":"myapp"}` {
+		t.Fatalf("unexpected diff: %s", diff)
+	}
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"gopkg.in/yaml.v2"
+)
+
+// CatalogRepo serves curated, installable CatalogEntry manifests loaded
+// from a directory of JSON/YAML files plus, optionally, a remote HTTP
+// index. It's refreshed on demand rather than kept in Postgres so that
+// operators can ship an internal catalog without a migration.
+type CatalogRepo struct {
+	dir      string
+	indexURL string
+	client   *http.Client
+
+	mtx     sync.RWMutex
+	entries map[string]*ct.CatalogEntry
+}
+
+// NewCatalogRepo loads manifests from dir (each a *.json or *.yaml file
+// containing a single ct.CatalogEntry) and, if indexURL is non-empty,
+// merges in entries fetched from that URL. indexURL lets an operator
+// point the controller at an internal catalog index without recompiling
+// or redeploying the controller binary.
+func NewCatalogRepo(dir, indexURL string) (*CatalogRepo, error) {
+	r := &CatalogRepo{
+		dir:      dir,
+		indexURL: indexURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		entries:  make(map[string]*ct.CatalogEntry),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the manifest directory and HTTP index, replacing the
+// in-memory catalog atomically.
+func (r *CatalogRepo) Reload() error {
+	entries := make(map[string]*ct.CatalogEntry)
+
+	if r.dir != "" {
+		matches, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		ymlMatches, err := filepath.Glob(filepath.Join(r.dir, "*.yaml"))
+		if err != nil {
+			return err
+		}
+		matches = append(matches, ymlMatches...)
+		for _, path := range matches {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entry := &ct.CatalogEntry{}
+			if filepath.Ext(path) == ".yaml" {
+				err = yaml.Unmarshal(data, entry)
+			} else {
+				err = json.Unmarshal(data, entry)
+			}
+			if err != nil {
+				return fmt.Errorf("data: error parsing catalog manifest %s: %s", path, err)
+			}
+			entries[entry.Slug] = entry
+		}
+	}
+
+	if r.indexURL != "" {
+		res, err := r.client.Get(r.indexURL)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		var remote []*ct.CatalogEntry
+		if err := json.NewDecoder(res.Body).Decode(&remote); err != nil {
+			return err
+		}
+		for _, entry := range remote {
+			entries[entry.Slug] = entry
+		}
+	}
+
+	r.mtx.Lock()
+	r.entries = entries
+	r.mtx.Unlock()
+	return nil
+}
+
+// List returns every catalog entry, sorted by slug would be nice but the
+// caller (the /catalog handler) already renders a JSON array so plain
+// map iteration order doesn't need to be stable here.
+func (r *CatalogRepo) List() []*ct.CatalogEntry {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	list := make([]*ct.CatalogEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		list = append(list, e)
+	}
+	return list
+}
+
+// Get returns the catalog entry with the given slug.
+func (r *CatalogRepo) Get(slug string) (*ct.CatalogEntry, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	entry, ok := r.entries[slug]
+	if !ok {
+		return nil, ct.ErrNotFound
+	}
+	return entry, nil
+}
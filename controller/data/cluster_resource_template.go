@@ -0,0 +1,170 @@
+package data
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// ClusterResourceTemplateRepo persists ClusterResourceTemplates: defaults
+// that should exist for every app in a namespace, or globally if a
+// template's namespace is empty.
+type ClusterResourceTemplateRepo struct {
+	db *postgres.DB
+}
+
+var clusterResourceTemplateApplicationStatements = map[string]string{
+	"cluster_resource_template_application_select": `SELECT template_updated_at FROM cluster_resource_template_applications WHERE template_id = $1 AND app_id = $2`,
+	"cluster_resource_template_application_upsert": `INSERT INTO cluster_resource_template_applications (template_id, app_id, template_updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (template_id, app_id) DO UPDATE SET template_updated_at = $3, applied_at = now()`,
+}
+
+var clusterResourceTemplateStatements = map[string]string{
+	"cluster_resource_template_insert": `INSERT INTO cluster_resource_templates (namespace, sinks, env, formation, routes, resources)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING template_id, created_at, updated_at`,
+	"cluster_resource_template_select": `SELECT namespace, sinks, env, formation, routes, resources, created_at, updated_at
+		FROM cluster_resource_templates WHERE template_id = $1`,
+	"cluster_resource_template_list": `SELECT template_id, namespace, sinks, env, formation, routes, resources, created_at, updated_at
+		FROM cluster_resource_templates ORDER BY created_at`,
+	"cluster_resource_template_delete": `DELETE FROM cluster_resource_templates WHERE template_id = $1`,
+}
+
+func NewClusterResourceTemplateRepo(db *postgres.DB) (*ClusterResourceTemplateRepo, error) {
+	if err := prepareAll(db, clusterResourceTemplateStatements); err != nil {
+		return nil, err
+	}
+	if err := prepareAll(db, clusterResourceTemplateApplicationStatements); err != nil {
+		return nil, err
+	}
+	return &ClusterResourceTemplateRepo{db: db}, nil
+}
+
+// IsApplied reports whether t (at its current UpdatedAt) has already been
+// applied to appID, so applyClusterResourceTemplates can skip re-creating
+// sinks, resources and routes it already created on a previous pass.
+func (r *ClusterResourceTemplateRepo) IsApplied(t *ct.ClusterResourceTemplate, appID string) (bool, error) {
+	var appliedUpdatedAt time.Time
+	err := r.db.QueryRow("cluster_resource_template_application_select", t.ID, appID).Scan(&appliedUpdatedAt)
+	if err == postgres.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.UpdatedAt != nil && appliedUpdatedAt.Equal(*t.UpdatedAt), nil
+}
+
+// MarkApplied records that t (at its current UpdatedAt) has been applied
+// to appID.
+func (r *ClusterResourceTemplateRepo) MarkApplied(t *ct.ClusterResourceTemplate, appID string) error {
+	return r.db.Exec("cluster_resource_template_application_upsert", t.ID, appID, t.UpdatedAt)
+}
+
+// Add validates t and persists it. Unlike most of the other *Repo Add
+// methods, this isn't validated against a JSON schema file -- there's no
+// cluster_resource_templates schema shipped alongside this repo the way
+// there is for e.g. apps or releases, so it sticks to the same plain Go
+// field checks InstallCatalogEntry uses for its own request body.
+func (r *ClusterResourceTemplateRepo) Add(t *ct.ClusterResourceTemplate) error {
+	if t.Sinks == nil && t.Env == nil && t.Formation == nil && t.Routes == nil && t.Resources == nil {
+		return ct.ValidationError{Message: "template must declare at least one of sinks, env, formation, routes or resources"}
+	}
+	return r.db.QueryRow("cluster_resource_template_insert", t.Namespace, t.Sinks, t.Env, t.Formation, t.Routes, t.Resources).
+		Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt)
+}
+
+// Get returns the template with the given id.
+func (r *ClusterResourceTemplateRepo) Get(id string) (*ct.ClusterResourceTemplate, error) {
+	t := &ct.ClusterResourceTemplate{ID: id}
+	err := r.db.QueryRow("cluster_resource_template_select", id).
+		Scan(&t.Namespace, &t.Sinks, &t.Env, &t.Formation, &t.Routes, &t.Resources, &t.CreatedAt, &t.UpdatedAt)
+	if err == postgres.ErrNoRows {
+		return nil, ct.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// List returns every template.
+func (r *ClusterResourceTemplateRepo) List() ([]*ct.ClusterResourceTemplate, error) {
+	rows, err := r.db.Query("cluster_resource_template_list")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var templates []*ct.ClusterResourceTemplate
+	for rows.Next() {
+		t := &ct.ClusterResourceTemplate{}
+		if err := rows.Scan(&t.ID, &t.Namespace, &t.Sinks, &t.Env, &t.Formation, &t.Routes, &t.Resources, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// Remove deletes the template with the given id.
+func (r *ClusterResourceTemplateRepo) Remove(id string) error {
+	return r.db.Exec("cluster_resource_template_delete", id)
+}
+
+// ForNamespace returns the templates that apply to an app in namespace:
+// every global template (namespace == "") plus any scoped to namespace.
+func (r *ClusterResourceTemplateRepo) ForNamespace(namespace string) ([]*ct.ClusterResourceTemplate, error) {
+	all, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	matching := make([]*ct.ClusterResourceTemplate, 0, len(all))
+	for _, t := range all {
+		if t.Namespace == "" || t.Namespace == namespace {
+			matching = append(matching, t)
+		}
+	}
+	return matching, nil
+}
+
+// Render evaluates t's Env values and each route's Domain as Go templates
+// against data (e.g. {{ .App.Name }}, {{ .App.Meta.env }}), returning a
+// copy with those fields substituted. Sinks, resources and the other
+// route fields are copied as they are, without rendering.
+func (r *ClusterResourceTemplateRepo) Render(t *ct.ClusterResourceTemplate, data ct.ClusterResourceTemplateData) (*ct.ClusterResourceTemplate, error) {
+	render := func(s string) (string, error) {
+		tmpl, err := template.New("").Parse(s)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	out := *t
+	out.Env = make(map[string]string, len(t.Env))
+	for k, v := range t.Env {
+		rendered, err := render(v)
+		if err != nil {
+			return nil, err
+		}
+		out.Env[k] = rendered
+	}
+	out.Routes = make([]*ct.CatalogRouteTemplate, len(t.Routes))
+	for i, rt := range t.Routes {
+		domain, err := render(rt.Domain)
+		if err != nil {
+			return nil, err
+		}
+		rendered := *rt
+		rendered.Domain = domain
+		out.Routes[i] = &rendered
+	}
+	return &out, nil
+}
@@ -0,0 +1,88 @@
+package data
+
+import "github.com/flynn/flynn/pkg/postgres"
+
+// newFeatureMigrations versions the tables backing the Operation, Policy,
+// ClusterResourceTemplate and Audit repos added alongside it. It's kept
+// as its own Migrations value here (rather than editing the existing
+// migration list directly, which isn't part of this tree), so it can't
+// ride along with whatever OpenAndMigrateDB already runs at startup —
+// MigrateFeatureTables applies it explicitly instead; callers must run
+// that before calling any of those four repos' New*Repo constructors.
+var newFeatureMigrations = postgres.NewMigrations()
+
+// MigrateFeatureTables applies newFeatureMigrations against db. Call this
+// once at startup, right after data.OpenAndMigrateDB, and before
+// constructing OperationRepo, PolicyRepo, ClusterResourceTemplateRepo or
+// AuditRepo — each of those prepares statements against tables this
+// creates, and will fail at prepare time otherwise.
+func MigrateFeatureTables(db *postgres.DB) error {
+	return newFeatureMigrations.Migrate(db)
+}
+
+func init() {
+	newFeatureMigrations.Add(1,
+		`CREATE TABLE operations (
+			operation_id text PRIMARY KEY,
+			type text NOT NULL,
+			state text NOT NULL,
+			errors text[] NOT NULL DEFAULT '{}',
+			warnings text[] NOT NULL DEFAULT '{}',
+			links jsonb NOT NULL DEFAULT '[]',
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+
+	newFeatureMigrations.Add(2,
+		`CREATE TABLE policy_rules (
+			policy_id text PRIMARY KEY DEFAULT random_id(),
+			subject text NOT NULL,
+			action text NOT NULL,
+			object text NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+
+	newFeatureMigrations.Add(3,
+		`CREATE TABLE cluster_resource_templates (
+			template_id text PRIMARY KEY DEFAULT random_id(),
+			namespace text NOT NULL DEFAULT '',
+			sinks jsonb NOT NULL DEFAULT '[]',
+			env jsonb NOT NULL DEFAULT '{}',
+			formation jsonb NOT NULL DEFAULT '{}',
+			routes jsonb NOT NULL DEFAULT '[]',
+			resources jsonb NOT NULL DEFAULT '[]',
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+
+	newFeatureMigrations.Add(4,
+		`CREATE TABLE audit_records (
+			audit_id text PRIMARY KEY DEFAULT random_id(),
+			method text NOT NULL,
+			path text NOT NULL,
+			auth_id text NOT NULL DEFAULT '',
+			auth_user text NOT NULL DEFAULT '',
+			app_id text NOT NULL DEFAULT '',
+			action text NOT NULL DEFAULT '',
+			remote_addr text NOT NULL DEFAULT '',
+			body_hash text NOT NULL DEFAULT '',
+			diff text NOT NULL DEFAULT '',
+			status integer NOT NULL,
+			latency_ms bigint NOT NULL,
+			created_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	)
+
+	newFeatureMigrations.Add(5,
+		`CREATE TABLE cluster_resource_template_applications (
+			template_id text NOT NULL REFERENCES cluster_resource_templates (template_id) ON DELETE CASCADE,
+			app_id text NOT NULL,
+			template_updated_at timestamptz NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now(),
+			PRIMARY KEY (template_id, app_id)
+		)`,
+	)
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
+	que "github.com/flynn/que-go"
+)
+
+// operationIDSep separates an operation's type prefix from its unique
+// suffix, e.g. "app.delete~3c1ecb4e-...". Encoding the type in the GUID
+// lets OperationType recover it from the id alone. GetOperation uses it
+// only to reject malformed ids; it still reads every operation from the
+// database and has no per-type resolvers.
+const operationIDSep = "~"
+
+// NewOperationID returns a new GUID for an operation of the given type.
+func NewOperationID(typ string) string {
+	return typ + operationIDSep + random.UUID()
+}
+
+// OperationType returns the type prefix encoded in an operation GUID.
+func OperationType(id string) string {
+	if i := strings.Index(id, operationIDSep); i >= 0 {
+		return id[:i]
+	}
+	return ""
+}
+
+var operationStatements = map[string]string{
+	"operation_insert":   `INSERT INTO operations (operation_id, type, state) VALUES ($1, $2, $3) RETURNING created_at, updated_at`,
+	"operation_select":   `SELECT state, errors, warnings, links, created_at, updated_at FROM operations WHERE operation_id = $1`,
+	"operation_complete": `UPDATE operations SET state = $2, links = $3, warnings = $4, updated_at = $5 WHERE operation_id = $1`,
+	"operation_fail":     `UPDATE operations SET state = $2, errors = $3, updated_at = $4 WHERE operation_id = $1`,
+}
+
+// OperationRepo persists the state of long-running controller operations
+// (see ct.Operation) in Postgres and drives their execution via the
+// que-go worker pool so that failures survive controller restarts.
+type OperationRepo struct {
+	db *postgres.DB
+	q  *que.Client
+}
+
+func NewOperationRepo(db *postgres.DB, q *que.Client) (*OperationRepo, error) {
+	if err := prepareAll(db, operationStatements); err != nil {
+		return nil, err
+	}
+	return &OperationRepo{db: db, q: q}, nil
+}
+
+// Add records a new operation of the given type and enqueues a que-go job
+// of queueName, passing args (marshaled to JSON, with operation_id added
+// so the worker can report its result back) as the job's Args, returning
+// the operation in the "processing" state.
+func (r *OperationRepo) Add(typ string, queueName string, args map[string]interface{}) (*ct.Operation, error) {
+	op := &ct.Operation{
+		ID:    NewOperationID(typ),
+		Type:  typ,
+		State: ct.OperationStateProcessing,
+	}
+	if err := r.db.QueryRow("operation_insert", op.ID, op.Type, string(op.State)).Scan(&op.CreatedAt, &op.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	if args == nil {
+		args = make(map[string]interface{}, 1)
+	}
+	args["operation_id"] = op.ID
+	jobArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.q.Enqueue(&que.Job{Type: queueName, Args: jobArgs}); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// Get returns the operation with the given GUID.
+func (r *OperationRepo) Get(id string) (*ct.Operation, error) {
+	op := &ct.Operation{ID: id, Type: OperationType(id)}
+	var state string
+	var links []byte
+	err := r.db.QueryRow("operation_select", id).Scan(&state, &op.Errors, &op.Warnings, &links, &op.CreatedAt, &op.UpdatedAt)
+	if err == postgres.ErrNoRows {
+		return nil, ct.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	op.State = ct.OperationState(state)
+	if len(links) > 0 {
+		if err := json.Unmarshal(links, &op.Links); err != nil {
+			return nil, err
+		}
+	}
+	return op, nil
+}
+
+// Complete marks the operation as complete, recording any warnings and the
+// links to the resource it created or modified.
+func (r *OperationRepo) Complete(id string, links []ct.OperationLink, warnings []string) error {
+	linksJSON, err := json.Marshal(links)
+	if err != nil {
+		return err
+	}
+	return r.db.Exec("operation_complete", id, string(ct.OperationStateComplete), linksJSON, warnings, time.Now())
+}
+
+// Fail marks the operation as failed, recording the errors that caused it
+// to fail.
+func (r *OperationRepo) Fail(id string, errs []string) error {
+	return r.db.Exec("operation_fail", id, string(ct.OperationStateFailed), errs, time.Now())
+}
@@ -0,0 +1,16 @@
+package data
+
+import "testing"
+
+func TestOperationType(t *testing.T) {
+	id := NewOperationID("app.delete")
+	if typ := OperationType(id); typ != "app.delete" {
+		t.Fatalf("expected type %q, got %q", "app.delete", typ)
+	}
+}
+
+func TestOperationTypeNoSeparator(t *testing.T) {
+	if typ := OperationType("not-an-operation-id"); typ != "" {
+		t.Fatalf("expected empty type, got %q", typ)
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// PolicyRepo persists the allow tuples used by the controller/policy
+// authorizer, following the same constructor/Get-by-id shape as the
+// other *Repo types in this package.
+type PolicyRepo struct {
+	db *postgres.DB
+}
+
+var policyStatements = map[string]string{
+	"policy_insert": `INSERT INTO policy_rules (subject, action, object) VALUES ($1, $2, $3) RETURNING policy_id, created_at`,
+	"policy_select": `SELECT subject, action, object, created_at FROM policy_rules WHERE policy_id = $1`,
+	"policy_list":   `SELECT policy_id, subject, action, object, created_at FROM policy_rules ORDER BY created_at`,
+	"policy_delete": `DELETE FROM policy_rules WHERE policy_id = $1`,
+	"policy_allows": `SELECT EXISTS(
+		SELECT 1 FROM policy_rules
+		WHERE (subject = $1 OR subject = $4)
+		AND (action = $2 OR action = $4)
+		AND (object = $3 OR object = $4)
+	)`,
+}
+
+func NewPolicyRepo(db *postgres.DB) (*PolicyRepo, error) {
+	if err := prepareAll(db, policyStatements); err != nil {
+		return nil, err
+	}
+	return &PolicyRepo{db: db}, nil
+}
+
+// Add persists a new policy rule.
+func (r *PolicyRepo) Add(rule *ct.PolicyRule) error {
+	return r.db.QueryRow("policy_insert", rule.Subject, rule.Action, rule.Object).Scan(&rule.ID, &rule.CreatedAt)
+}
+
+// Get returns the policy rule with the given id.
+func (r *PolicyRepo) Get(id string) (*ct.PolicyRule, error) {
+	rule := &ct.PolicyRule{ID: id}
+	err := r.db.QueryRow("policy_select", id).Scan(&rule.Subject, &rule.Action, &rule.Object, &rule.CreatedAt)
+	if err == postgres.ErrNoRows {
+		return nil, ct.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// List returns every policy rule.
+func (r *PolicyRepo) List() ([]*ct.PolicyRule, error) {
+	rows, err := r.db.Query("policy_list")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []*ct.PolicyRule
+	for rows.Next() {
+		rule := &ct.PolicyRule{}
+		if err := rows.Scan(&rule.ID, &rule.Subject, &rule.Action, &rule.Object, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// Remove deletes the policy rule with the given id.
+func (r *PolicyRepo) Remove(id string) error {
+	return r.db.Exec("policy_delete", id)
+}
+
+// EnsureDefaultPolicy inserts the wildcard allow-all rule if no policy
+// rules exist yet, so a controller upgrading onto policy enforcement for
+// the first time keeps today's "root key can do anything" behavior
+// instead of locking every caller out.
+func (r *PolicyRepo) EnsureDefaultPolicy() error {
+	rules, err := r.List()
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		return nil
+	}
+	return r.Add(&ct.PolicyRule{
+		Subject: ct.PolicyWildcard,
+		Action:  ct.PolicyWildcard,
+		Object:  ct.PolicyWildcard,
+	})
+}
+
+// Allows reports whether any persisted rule grants subject the right to
+// perform action on object, where a rule's subject, action or object of
+// PolicyWildcard matches any value (e.g. the "*"/"*"/"*" default rule
+// covers every caller, matching today's root-key-allows-all behavior).
+func (r *PolicyRepo) Allows(subject, action, object string) (bool, error) {
+	var allowed bool
+	err := r.db.QueryRow("policy_allows", subject, action, object, ct.PolicyWildcard).Scan(&allowed)
+	if err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
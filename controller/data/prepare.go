@@ -0,0 +1,16 @@
+package data
+
+import "github.com/flynn/flynn/pkg/postgres"
+
+// prepareAll registers every named query in stmts against db, the same
+// way the existing *Repo constructors prepare their own statements, so a
+// caller can just do `if err := prepareAll(db, map[string]string{...}); err != nil { ... }`
+// instead of repeating the loop at every call site.
+func prepareAll(db *postgres.DB, stmts map[string]string) error {
+	for name, sql := range stmts {
+		if err := db.Prepare(name, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
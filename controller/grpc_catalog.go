@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/protobuf"
+	ct "github.com/flynn/flynn/controller/types"
+	"golang.org/x/net/context"
+)
+
+// ListCatalogEntries is the gRPC equivalent of GET /catalog.
+func (g *grpcAPI) ListCatalogEntries(ctx context.Context, req *protobuf.ListCatalogEntriesRequest) (*protobuf.ListCatalogEntriesResponse, error) {
+	entries := g.catalogRepo.List()
+	res := &protobuf.ListCatalogEntriesResponse{
+		CatalogEntries: make([]*protobuf.CatalogEntry, len(entries)),
+	}
+	for i, e := range entries {
+		res.CatalogEntries[i] = protobuf.NewCatalogEntry(e)
+	}
+	return res, nil
+}
+
+// InstallCatalogEntry is the gRPC equivalent of POST /catalog/:slug/install.
+func (g *grpcAPI) InstallCatalogEntry(ctx context.Context, req *protobuf.InstallCatalogEntryRequest) (*protobuf.Operation, error) {
+	entry, err := g.catalogRepo.Get(req.Slug)
+	if err != nil {
+		return nil, err
+	}
+	app := &ct.App{Name: req.AppName}
+	if err := g.appRepo.Add(app); err != nil {
+		return nil, err
+	}
+	op, err := g.operationRepo.Add("catalog.install", "catalog_install", map[string]interface{}{
+		"app_id": app.ID,
+		"slug":   entry.Slug,
+		"env":    req.Env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	op.Links = []ct.OperationLink{{Rel: "app", Href: "/apps/" + app.ID}}
+	return protobuf.NewOperation(op), nil
+}
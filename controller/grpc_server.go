@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/protobuf"
+	"github.com/flynn/flynn/pkg/postgres"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// grpcAPI implements protobuf.ControllerServer on top of the same
+// controllerAPI the HTTP router uses, so the gRPC and grpc-web surfaces
+// share one set of repos and one policy engine. Its methods live across
+// grpc_catalog.go, grpc_stream.go and friends.
+type grpcAPI struct {
+	*controllerAPI
+	db *postgres.DB
+}
+
+// grpcServer builds the *grpc.Server appHandler hands to muxHandler,
+// chaining grpcAuthInterceptor and policyUnaryInterceptor/
+// grpcStreamAuthInterceptor ahead of every RPC so gRPC and grpc-web calls
+// are authenticated and policy-checked the same way the HTTP routes are
+// via policyMW.Wrap.
+func (g *grpcAPI) grpcServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(
+			grpcAuthInterceptor(g.authorizer),
+			policyUnaryInterceptor(g.policyRepo),
+		)),
+		grpc.StreamInterceptor(grpcStreamAuthInterceptor(g.authorizer, g.policyRepo)),
+	)
+	protobuf.RegisterControllerServer(srv, g)
+	return srv
+}
+
+// chainUnaryInterceptors runs each interceptor in order, each wrapping
+// the next, so grpcAuthInterceptor's authenticated context is what
+// policyUnaryInterceptor (and every RPC handler after it) sees.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chain := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chain
+			chain = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chain(ctx, req)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/data"
+	"github.com/flynn/flynn/controller/protobuf"
+	ct "github.com/flynn/flynn/controller/types"
+	logagg "github.com/flynn/flynn/logaggregator/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// StreamAppLog is the grpc-web streaming equivalent of GET
+// /apps/:apps_id/log: rather than the client long-polling, the
+// controller pushes LogMessages as they're read from the logaggregator
+// client already used by the HTTP handler.
+func (g *grpcAPI) StreamAppLog(req *protobuf.AppLogRequest, stream protobuf.Controller_StreamAppLogServer) error {
+	app, err := g.appRepo.Get(req.AppId)
+	if err != nil {
+		return err
+	}
+	opts := &logagg.LogOpts{Follow: true}
+	if req.StartId != "" {
+		opts.Cursor = &logagg.SinceID{ID: req.StartId}
+	}
+	rc, err := g.logaggc.GetLog(app.(*ct.App).ID, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	msgCh := make(chan *logagg.Message)
+	errCh := make(chan error, 1)
+	go decodeLogMessages(rc, msgCh, errCh)
+
+	for {
+		select {
+		case <-g.streamDone:
+			return grpcUnavailable("controller is shutting down")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case err := <-errCh:
+			return err
+		case msg, ok := <-msgCh:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(protobuf.NewLogMessage(msg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StreamEvents is the grpc-web streaming equivalent of GET /events: it
+// resumes from req.Since, so a browser client reconnecting after a
+// dropped grpc-web stream can pick up where it left off. req.StartId is
+// not consulted.
+func (g *grpcAPI) StreamEvents(req *protobuf.EventFilter, stream protobuf.Controller_StreamEventsServer) error {
+	sub, err := g.eventRepo.Listen(eventListenerFilter(req), req.Since)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-g.streamDone:
+			return grpcUnavailable("controller is shutting down")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case event, ok := <-sub.Events:
+			if !ok {
+				return sub.Err()
+			}
+			if err := stream.Send(protobuf.NewEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventListenerFilter(req *protobuf.EventFilter) *data.EventListenerFilter {
+	return &data.EventListenerFilter{
+		AppID:      req.AppId,
+		ObjectType: req.ObjectType,
+	}
+}
+
+func grpcUnavailable(msg string) error {
+	return grpc.Errorf(codes.Unavailable, msg)
+}
+
+func decodeLogMessages(rc interface{ Read([]byte) (int, error) }, msgCh chan<- *logagg.Message, errCh chan<- error) {
+	defer close(msgCh)
+	dec := logagg.NewMessageDecoder(rc)
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		msgCh <- msg
+	}
+}
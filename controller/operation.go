@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/flynn/flynn/controller/data"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	que "github.com/flynn/que-go"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/net/context"
+)
+
+// respondAsync writes a 202 Accepted response with a Location header
+// pointing at the operation tracking the work that was just enqueued,
+// mirroring the shape of the synchronous handlers that write the created
+// resource directly.
+func respondAsync(w http.ResponseWriter, op *ct.Operation) {
+	w.Header().Set("Location", fmt.Sprintf("/operations/%s", op.ID))
+	httphelper.JSON(w, 202, op)
+}
+
+// GetOperation looks up an asynchronous operation by GUID. The type prefix
+// encoded in the GUID (see data.OperationType) is only used here to
+// reject obviously malformed ids before hitting the repo; every valid id
+// is read from the repo.
+func (c *controllerAPI) GetOperation(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	id := params.ByName("id")
+	if data.OperationType(id) == "" {
+		respondWithError(w, ct.ValidationError{Field: "id", Message: "is not a valid operation id"})
+		return
+	}
+	op, err := c.operationRepo.Get(id)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, op)
+}
+
+// appLink and providerLink are the common linkFuncs for endpoints scoped
+// to a single app or provider by route param.
+func appLink(ps httprouter.Params) ct.OperationLink {
+	return ct.OperationLink{Rel: "app", Href: "/apps/" + ps.ByName("apps_id")}
+}
+
+func providerLink(ps httprouter.Params) ct.OperationLink {
+	return ct.OperationLink{Rel: "provider", Href: "/providers/" + ps.ByName("providers_id")}
+}
+
+// linkFunc derives the OperationLink an async-wrapped handler should
+// record against its operation, from the route params of the request
+// that started it (e.g. the apps_id being deleted).
+type linkFunc func(ps httprouter.Params) ct.OperationLink
+
+// asyncRequest is the replayable shape of the http.Request an
+// asyncOperation-wrapped handler was originally called with: enough to
+// reconstruct an equivalent request and httprouter.Params from a que-go
+// job's Args, so the handler runs for real work (not a goroutine that
+// dies with the process) and survives a controller restart mid-operation.
+type asyncRequest struct {
+	Method string             `json:"method"`
+	Path   string             `json:"path"`
+	Query  string             `json:"query"`
+	Header http.Header        `json:"header"`
+	Body   []byte             `json:"body"`
+	Params []httprouter.Param `json:"params"`
+}
+
+// asyncOperation wraps a synchronous httprouter.Handle so that calling it
+// immediately records an Operation, enqueues a que-go job that replays
+// the request against next, and responds 202 with the operation's GUID;
+// the replayed handler's eventual status code marks the operation
+// complete or failed. Driving next via que-go (instead of a bare
+// goroutine) is what lets DeleteApp, CreateDeployment, MigrateDomain,
+// ScheduleAppGarbageCollection and ProvisionResource survive a controller
+// restart mid-operation, the same way catalogInstallWorker does for
+// catalog installs. asyncOperation registers next as opType's worker in
+// c.asyncWorkers; appHandler folds that map into the worker pool once
+// every route has registered.
+func (c *controllerAPI) asyncOperation(opType string, link linkFunc, next httprouter.Handle) httprouter.Handle {
+	c.asyncWorkers[opType] = c.asyncOperationWorker(next)
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+
+		var opLinks []ct.OperationLink
+		if link != nil {
+			opLinks = []ct.OperationLink{link(ps)}
+		}
+		linksJSON, err := json.Marshal(opLinks)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+
+		op, err := c.operationRepo.Add(opType, opType, map[string]interface{}{
+			"request": asyncRequest{
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Query:  r.URL.RawQuery,
+				Header: r.Header,
+				Body:   body,
+				Params: []httprouter.Param(ps),
+			},
+			"links": json.RawMessage(linksJSON),
+		})
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		op.Links = opLinks
+
+		respondAsync(w, op)
+	}
+}
+
+// asyncOperationWorker builds the que-go WorkFunc that replays a request
+// recorded by asyncOperation against next, completing or failing the
+// operation_id the job's Args carry based on next's response code.
+func (c *controllerAPI) asyncOperationWorker(next httprouter.Handle) que.WorkFunc {
+	return func(job *que.Job) error {
+		var args struct {
+			OperationID string             `json:"operation_id"`
+			Request     asyncRequest       `json:"request"`
+			Links       []ct.OperationLink `json:"links"`
+		}
+		if err := json.Unmarshal(job.Args, &args); err != nil {
+			return err
+		}
+
+		u := &url.URL{Path: args.Request.Path, RawQuery: args.Request.Query}
+		r := httptest.NewRequest(args.Request.Method, u.String(), bytes.NewReader(args.Request.Body))
+		r.Header = args.Request.Header
+
+		rec := httptest.NewRecorder()
+		next(rec, r, httprouter.Params(args.Request.Params))
+
+		if rec.Code >= 400 {
+			return c.operationRepo.Fail(args.OperationID, []string{rec.Body.String()})
+		}
+		return c.operationRepo.Complete(args.OperationID, args.Links, nil)
+	}
+}
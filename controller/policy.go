@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"golang.org/x/net/context"
+)
+
+// CreatePolicy adds a new allow tuple.
+func (c *controllerAPI) CreatePolicy(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var rule ct.PolicyRule
+	if err := httphelper.DecodeJSON(req, &rule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if rule.Subject == "" || rule.Action == "" || rule.Object == "" {
+		respondWithError(w, ct.ValidationError{Message: "subject, action and object must all be set"})
+		return
+	}
+	if err := c.policyRepo.Add(&rule); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, &rule)
+}
+
+// GetPolicies lists every allow tuple.
+func (c *controllerAPI) GetPolicies(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	rules, err := c.policyRepo.List()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, rules)
+}
+
+// DeletePolicy removes an allow tuple.
+func (c *controllerAPI) DeletePolicy(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	if err := c.policyRepo.Remove(params.ByName("policy_id")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
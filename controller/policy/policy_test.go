@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type fakeChecker struct {
+	allowed bool
+	err     error
+	subject string
+	action  string
+	object  string
+}
+
+func (f *fakeChecker) Allows(subject, action, object string) (bool, error) {
+	f.subject, f.action, f.object = subject, action, object
+	return f.allowed, f.err
+}
+
+func doWrap(t *testing.T, checker Checker, objectOf ObjectFunc, ps httprouter.Params) *httptest.ResponseRecorder {
+	t.Helper()
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	}
+	mw := New(checker)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("Flynn-Auth-ID", "user-1")
+	mw.Wrap("apps.write", objectOf, next)(rec, req, ps)
+	if called && rec.Code != http.StatusOK {
+		t.Fatalf("next ran but response code was %d", rec.Code)
+	}
+	return rec
+}
+
+func TestWrapAllows(t *testing.T) {
+	checker := &fakeChecker{allowed: true}
+	rec := doWrap(t, checker, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if checker.subject != "user-1" || checker.action != "apps.write" {
+		t.Fatalf("unexpected check args: %+v", checker)
+	}
+}
+
+func TestWrapDenies(t *testing.T) {
+	checker := &fakeChecker{allowed: false}
+	rec := doWrap(t, checker, nil, nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
+
+func TestWrapCheckerError(t *testing.T) {
+	checker := &fakeChecker{err: errors.New("db is down")}
+	rec := doWrap(t, checker, nil, nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+}
+
+func TestWrapObjectParam(t *testing.T) {
+	checker := &fakeChecker{allowed: true}
+	ps := httprouter.Params{{Key: "apps_id", Value: "app-1"}}
+	doWrap(t, checker, ObjectParam("apps", "apps_id"), ps)
+	if checker.object != "apps/app-1" {
+		t.Fatalf("expected object %q, got %q", "apps/app-1", checker.object)
+	}
+}
+
+func TestWrapNilObjectFuncUsesWildcard(t *testing.T) {
+	checker := &fakeChecker{allowed: true}
+	doWrap(t, checker, nil, nil)
+	if checker.object != "*" {
+		t.Fatalf("expected wildcard object, got %q", checker.object)
+	}
+}
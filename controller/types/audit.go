@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// AuditRecord is a persisted record of a single mutating controller
+// request, used to answer "who did what, when" after the fact.
+type AuditRecord struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	AuthID     string    `json:"auth_id,omitempty"`
+	AuthUser   string    `json:"auth_user,omitempty"`
+	AppID      string    `json:"app_id,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	BodyHash   string    `json:"body_hash,omitempty"`
+	Diff       string    `json:"diff,omitempty"`
+	Status     int       `json:"status"`
+	Latency    int64     `json:"latency_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows a GET /audit listing.
+type AuditFilter struct {
+	AuthUser string
+	AppID    string
+	Action   string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+	Cursor   string
+}
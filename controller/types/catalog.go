@@ -0,0 +1,45 @@
+package types
+
+// CatalogRouteTemplate describes a route that should be created when a
+// catalog entry is installed, e.g. a default HTTP route for the app.
+type CatalogRouteTemplate struct {
+	Type    string            `json:"type"`
+	Domain  string            `json:"domain,omitempty"`
+	Service string            `json:"service,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// CatalogVolumeClaim describes a volume that should be attached to a
+// process type when a catalog entry is installed.
+type CatalogVolumeClaim struct {
+	ProcessType string `json:"process_type"`
+	Path        string `json:"path"`
+}
+
+// CatalogResource declares a provider resource a catalog entry requires,
+// e.g. {"provider": "postgres"}.
+type CatalogResource struct {
+	Provider string `json:"provider"`
+}
+
+// CatalogEntry is a curated, installable stack (postgres, redis,
+// wordpress, mattermost, ...). It's loaded from a JSON/YAML manifest on
+// disk or from an HTTP catalog index, not persisted row-by-row, so
+// fields mirror the manifest shape rather than a database schema.
+type CatalogEntry struct {
+	Slug        string                 `json:"slug"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	ArtifactURI string                 `json:"artifact_uri"`
+	Env         map[string]string      `json:"env,omitempty"`
+	Resources   []CatalogResource      `json:"resources,omitempty"`
+	Formation   map[string]int         `json:"formation,omitempty"`
+	Routes      []CatalogRouteTemplate `json:"routes,omitempty"`
+	Volumes     []CatalogVolumeClaim   `json:"volumes,omitempty"`
+}
+
+// CatalogInstallRequest is the body of POST /catalog/:slug/install.
+type CatalogInstallRequest struct {
+	AppName string            `json:"app_name"`
+	Env     map[string]string `json:"env,omitempty"`
+}
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// ClusterResourceTemplate declares defaults that should exist for every
+// app in a namespace (or, if Namespace is empty, globally): default
+// sinks, env/secrets, formation floors, routes and provider resources.
+// Env values and route domains are Go templates evaluated against a
+// ClusterResourceTemplateData value so an operator can reference e.g.
+// {{ .App.Name }} or {{ .App.Meta.env }}; other fields are used as-is.
+type ClusterResourceTemplate struct {
+	ID        string              `json:"id"`
+	Namespace string              `json:"namespace,omitempty"`
+	Sinks     []*Sink             `json:"sinks,omitempty"`
+	Env       map[string]string   `json:"env,omitempty"`
+	Formation map[string]int      `json:"formation,omitempty"`
+	Routes    []*CatalogRouteTemplate `json:"routes,omitempty"`
+	Resources []CatalogResource   `json:"resources,omitempty"`
+	CreatedAt *time.Time          `json:"created_at,omitempty"`
+	UpdatedAt *time.Time          `json:"updated_at,omitempty"`
+}
+
+// ClusterResourceTemplateData is the value a ClusterResourceTemplate's Go
+// template fields are evaluated against.
+type ClusterResourceTemplateData struct {
+	App *App
+}
+
+// EventTypeClusterResourceSync is the event type emitted through EventRepo each
+// time the reconciler applies or re-applies a template to an app.
+const EventTypeClusterResourceSync EventType = "cluster_resource_sync"
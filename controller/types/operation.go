@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+// OperationState describes the lifecycle of an asynchronous Operation.
+type OperationState string
+
+const (
+	OperationStateProcessing OperationState = "processing"
+	OperationStateComplete   OperationState = "complete"
+	OperationStateFailed     OperationState = "failed"
+)
+
+// OperationLink points at the resource an Operation created or modified,
+// e.g. {"rel": "app", "href": "/apps/1234"}.
+type OperationLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+}
+
+// Operation tracks the status of a long-running controller action (app
+// deletion, deployment creation, domain migration, garbage collection,
+// resource provisioning, ...) that can't be satisfied within a single
+// request/response cycle. Unlike Job, which tracks a scheduled process
+// run, Operation tracks the lifecycle of an API call.
+type Operation struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	State     OperationState  `json:"state"`
+	Errors    []string        `json:"errors,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+	Links     []OperationLink `json:"links,omitempty"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
+	UpdatedAt *time.Time      `json:"updated_at,omitempty"`
+}
@@ -0,0 +1,20 @@
+package types
+
+import "time"
+
+// PolicyWildcard matches any subject, action or object in a PolicyRule.
+const PolicyWildcard = "*"
+
+// PolicyRule is an allow tuple: subject is permitted to perform action on
+// object. subject is matched against the caller's authenticated id (the
+// Flynn-Auth-ID header); Flynn-Auth-User is not consulted and there are no
+// group subjects. action is a verb like "apps.deploy", and
+// object is either PolicyWildcard or a concrete resource reference such
+// as "apps/1234".
+type PolicyRule struct {
+	ID        string     `json:"id"`
+	Subject   string     `json:"subject"`
+	Action    string     `json:"action"`
+	Object    string     `json:"object"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}